@@ -1,14 +1,24 @@
 package app
 
 import (
+	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/metcalf/saypi/auth"
+	"github.com/metcalf/saypi/idempotency"
+	"github.com/metcalf/saypi/metrics"
 	"github.com/metcalf/saypi/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/context"
 )
 
+// dbStatsInterval is how often New's background goroutine publishes
+// the DB connection pool's stats to metrics.ObserveDBStats.
+const dbStatsInterval = 15 * time.Second
+
 // Configuration represents the configuration for an App
 type Configuration struct {
 	DBDSN     string // postgres data source name
@@ -16,12 +26,32 @@ type Configuration struct {
 	DBMaxOpen int    // maximum number of open DB connections
 
 	UserSecret []byte // secret for generating secure user tokens
+
+	// EnableOAuth turns on the /authorize and /token routes, letting
+	// third-party clients request delegated access to a saypi account.
+	// It defaults to false since most deployments have no such clients
+	// to register.
+	EnableOAuth bool
+
+	// AuthBackends selects, by name and in order, which auth.Authenticator
+	// backends WrapC tries against incoming requests: "bearer", "basic",
+	// or "oidc". Defaults to []string{"bearer"} when empty.
+	AuthBackends []string
+
+	// OIDCIssuer, if set, enables the "oidc" backend, discovering its
+	// signing keys from OIDCIssuer's /.well-known/openid-configuration
+	// document. OIDCAudience, if set, is checked against tokens' `aud`
+	// claim.
+	OIDCIssuer   string
+	OIDCAudience string
 }
 
 // App encapsulates the handlers for the saypi API
 type App struct {
 	Srv     http.Handler
 	closers []io.Closer
+
+	bearerAuth *auth.BearerAuthenticator
 }
 
 // Close cleans up any resources used by the app such as database connections.
@@ -29,6 +59,14 @@ func (a *App) Close() error {
 	return closeAll(a.closers)
 }
 
+// RotateUserSecret replaces the secret used to sign new bearer tokens,
+// keeping the previous secret valid for gracePeriod so sessions signed
+// with it don't get invalidated mid-rotation. See
+// auth.BearerAuthenticator.RotateSecret.
+func (a *App) RotateUserSecret(secret []byte, gracePeriod time.Duration) {
+	a.bearerAuth.RotateSecret(secret, gracePeriod)
+}
+
 // New creates an App for the given configuration.
 func New(config *Configuration) (*App, error) {
 	var app App
@@ -39,15 +77,61 @@ func New(config *Configuration) (*App, error) {
 		return nil, err
 	}
 	app.closers = append(app.closers, db)
+	app.closers = append(app.closers, startDBStatsReporter(db))
+
+	bearerAuth := auth.NewBearerAuthenticator(config.UserSecret, db)
+	bearerAuth.EnableOAuth = config.EnableOAuth
+	app.closers = append(app.closers, bearerAuth)
+	app.bearerAuth = bearerAuth
+
+	available := map[string]auth.Authenticator{
+		"bearer": bearerAuth,
+		"basic":  auth.NewBasicAuthenticator(db),
+	}
+	if config.OIDCIssuer != "" {
+		oidcAuth, err := auth.NewOIDCAuthenticator(config.OIDCIssuer, config.OIDCAudience)
+		if err != nil {
+			defer app.Close()
+			return nil, err
+		}
+		available["oidc"] = oidcAuth
+	}
+
+	backendNames := config.AuthBackends
+	if len(backendNames) == 0 {
+		backendNames = []string{"bearer"}
+	}
+
+	var backends []auth.Authenticator
+	for _, name := range backendNames {
+		b, ok := available[name]
+		if !ok {
+			defer app.Close()
+			return nil, fmt.Errorf("app: unknown auth backend %q", name)
+		}
+		backends = append(backends, b)
+	}
 
-	authCtrl := auth.New(config.UserSecret)
+	authCtrl := auth.New(backends...)
+
+	idempotencyMW := idempotency.New(db)
+	app.closers = append(app.closers, idempotencyMW)
 
 	mainMux := mux.New()
 	privMux := mux.New()
-	mainMux.NotFoundHandler = authCtrl.WrapC(privMux)
+	// idempotencyMW scopes keys by userID, so it has to sit inside the
+	// auth chain even though conceptually it guards privMux's handlers
+	// before they see a request.
+	mainMux.NotFoundHandler = authCtrl.WrapC(idempotencyMW.WrapC(privMux))
+
+	authCtrl.Routes(mainMux)
 
-	mainMux.RouteFuncC("POST", "/users", authCtrl.CreateUser)
-	mainMux.RouteFuncC("GET", "/users/:id", authCtrl.GetUser)
+	mainMux.RouteFuncC("GET", "/metrics", metrics.Instrument(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		promhttp.Handler().ServeHTTP(w, r)
+	}))
+
+	privMux.RouteFuncC("DELETE", "/sessions/current", metrics.Instrument(bearerAuth.DeleteCurrentSession))
+	privMux.RouteFuncC("DELETE", "/sessions/:id", metrics.Instrument(bearerAuth.DeleteSession))
 
 	/*
 		privMux.RouteFuncC("GET", "/animals", sayCtrl.GetAnimals)
@@ -67,10 +151,45 @@ func New(config *Configuration) (*App, error) {
 		privMux.RouteFunc("DELETE", "/conversations/:name/lines/:id", sayCtrl.DeleteLine)
 	*/
 
-	// TODO: Wrap with error handling and logging
 	app.Srv = mainMux
 
-	return nil, nil
+	return &app, nil
+}
+
+// dbStatsCloser stops the goroutine startDBStatsReporter spawns.
+type dbStatsCloser struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (c *dbStatsCloser) Close() error {
+	close(c.stop)
+	<-c.done
+	return nil
+}
+
+// startDBStatsReporter periodically publishes db's connection pool
+// stats to Prometheus until the returned closer is closed.
+func startDBStatsReporter(db *sqlx.DB) io.Closer {
+	c := &dbStatsCloser{stop: make(chan struct{}), done: make(chan struct{})}
+
+	go func() {
+		defer close(c.done)
+
+		t := time.NewTicker(dbStatsInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				metrics.ObserveDBStats(db)
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+
+	return c
 }
 
 func buildDB(dsn string, maxIdle, maxOpen int) (*sqlx.DB, error) {
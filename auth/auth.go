@@ -1,96 +1,84 @@
 package auth
 
 import (
-	"crypto/hmac"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
 	"net/http"
-	"strings"
 
+	"github.com/metcalf/saypi/metrics"
 	"github.com/metcalf/saypi/mux"
 	"golang.org/x/net/context"
 )
 
-type Controller struct {
-	secret []byte
+// Authenticator authenticates incoming requests according to one
+// authentication scheme (bearer tokens, HTTP Basic, OIDC, ...).
+type Authenticator interface {
+	// Authenticate returns the local user id the request authenticates
+	// as. It returns an error if this backend doesn't recognize the
+	// request at all (e.g. its header is absent) so Controller.WrapC
+	// can give the next configured backend a chance.
+	Authenticate(r *http.Request) (userID string, err error)
+
+	// Routes registers this backend's own enrollment endpoints, if it
+	// has any, on mux.
+	Routes(mux *mux.Mux)
 }
 
-const (
-	idLen = 16
-)
-
-func New(secret []byte) *Controller {
-	return &Controller{secret}
+// Controller authenticates requests against a chain of configured
+// Authenticator backends, accepting the request on the first backend
+// that authenticates it, so a deployment can add OIDC or Basic auth
+// alongside the existing bearer tokens without anyone's credentials
+// breaking.
+type Controller struct {
+	backends []Authenticator
 }
 
-func (c *Controller) CreateUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	user := make([]byte, 0, idLen)
-	if _, err := rand.Read(user); err != nil {
-		panic(err)
-	}
-
-	mac := hmac.New(sha256.New, c.secret).Sum(user)
-	user = append(user, mac...)
-
-	res := struct {
-		ID string `json:"id"`
-	}{base64.URLEncoding.EncodeToString(user)}
-
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		// TODO: This shouldn't panic but handle some errors
-		panic(err)
-	}
+// New creates a Controller that authenticates requests against
+// backends, trying each in order.
+func New(backends ...Authenticator) *Controller {
+	return &Controller{backends}
 }
 
-func (c *Controller) GetUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	auth, ok := mux.GetURLVar(ctx, "id")
-	if !ok {
-		panic("GetUser called without an `id` URL Var")
-	}
-
-	if c.getUser(auth) != "" {
-		w.WriteHeader(204)
-	} else {
-		http.NotFound(w, r)
+// Routes registers every configured backend's enrollment endpoints.
+func (c *Controller) Routes(m *mux.Mux) {
+	for _, b := range c.backends {
+		b.Routes(m)
 	}
 }
 
 func (c *Controller) WrapC(inner mux.HandlerC) mux.HandlerC {
 	return mux.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") {
-			http.Error(w, "You must provide a Bearer token in an Authorization header", http.StatusUnauthorized)
-			return
+		var lastErr error
+
+		for _, b := range c.backends {
+			userID, err := b.Authenticate(r)
+			if err == nil {
+				inner.ServeHTTPC(context.WithValue(ctx, "userID", userID), w, r)
+				return
+			}
+			lastErr = err
 		}
 
-		auth = strings.TrimPrefix(auth, "Bearer ")
-
-		if c.getUser(auth) != "" {
-			inner.ServeHTTPC(context.WithValue(ctx, "userID", ""), w, r)
-		} else {
-			http.Error(w, "Invalid authentication string", http.StatusUnauthorized)
-		}
+		metrics.AuthFailure(authFailureReason(lastErr))
+		http.Error(w, "Unable to authenticate request", http.StatusUnauthorized)
 	})
 }
 
-func (c *Controller) getUser(auth string) string {
-	mac := hmac.New(sha256.New, c.secret)
-
-	raw, err := base64.URLEncoding.DecodeString(auth)
-	if err != nil {
-		return ""
-	}
-	if len(raw) != idLen+mac.Size() {
-		return ""
+// authFailureReason maps a backend's authentication error to a short,
+// low-cardinality tag for the saypi_auth_failures_total metric.
+func authFailureReason(err error) string {
+	switch err {
+	case errInvalidToken:
+		return "invalid_bearer_token"
+	case errBasicAuthMissing:
+		return "missing_basic_credentials"
+	case errBasicAuthInvalid:
+		return "invalid_basic_credentials"
+	case errOIDCTokenMissing:
+		return "missing_oidc_token"
+	case errOIDCTokenInvalid:
+		return "invalid_oidc_token"
+	case nil:
+		return "no_backend_configured"
+	default:
+		return "unknown"
 	}
-
-	id := raw[0:idLen]
-	msgMac := raw[idLen:]
-
-	if hmac.Equal(msgMac, mac.Sum(id)) {
-		return string(id)
-	}
-	return ""
-}
\ No newline at end of file
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestBasicAuthenticatorRejectsMissingCredentials(t *testing.T) {
+	a := NewBasicAuthenticator(nil)
+
+	r := httptest.NewRequest("GET", "/", nil)
+
+	if _, err := a.Authenticate(r); err != errBasicAuthMissing {
+		t.Errorf("err = %v, want errBasicAuthMissing", err)
+	}
+}
+
+// TestOIDCKeyFuncRejectsNonRSAAlgorithm guards against JWT
+// algorithm-confusion: a token claiming HS256 must not make it through
+// to key lookup, where an attacker could otherwise get the server to
+// HMAC-verify the token using the provider's public RSA key (which is,
+// by design, not secret) as the HMAC key.
+func TestOIDCKeyFuncRejectsNonRSAAlgorithm(t *testing.T) {
+	o := &OIDCAuthenticator{}
+
+	token := &jwt.Token{Method: jwt.SigningMethodHS256, Header: map[string]interface{}{}}
+
+	if _, err := o.keyFunc(token); err != errOIDCTokenInvalid {
+		t.Errorf("err = %v, want errOIDCTokenInvalid", err)
+	}
+}
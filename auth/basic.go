@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/metcalf/saypi/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	errBasicAuthMissing = errors.New("auth: no HTTP Basic credentials present")
+	errBasicAuthInvalid = errors.New("auth: invalid username or password")
+)
+
+// BasicAuthenticator authenticates requests using RFC 7617 HTTP Basic
+// credentials checked against bcrypt password hashes stored in
+// Postgres. It's meant for service-to-service or scripted clients that
+// can't perform an interactive OAuth2 flow.
+type BasicAuthenticator struct {
+	db *sqlx.DB
+}
+
+// NewBasicAuthenticator creates a BasicAuthenticator checking
+// credentials against db.
+func NewBasicAuthenticator(db *sqlx.DB) *BasicAuthenticator {
+	return &BasicAuthenticator{db}
+}
+
+// Routes is a no-op: basic_credentials rows are provisioned out of
+// band (e.g. by an operator) for now, there's no self-service
+// enrollment flow yet.
+func (a *BasicAuthenticator) Routes(m *mux.Mux) {}
+
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (string, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", errBasicAuthMissing
+	}
+
+	var cred struct {
+		UserID       string `db:"user_id"`
+		PasswordHash []byte `db:"password_hash"`
+	}
+	err := a.db.Get(&cred, `SELECT user_id, password_hash FROM basic_credentials WHERE username = $1`, username)
+	if err != nil {
+		return "", errBasicAuthInvalid
+	}
+
+	if err := bcrypt.CompareHashAndPassword(cred.PasswordHash, []byte(password)); err != nil {
+		return "", errBasicAuthInvalid
+	}
+
+	return cred.UserID, nil
+}
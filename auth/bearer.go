@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/metcalf/saypi/metrics"
+	"github.com/metcalf/saypi/mux"
+	"golang.org/x/net/context"
+)
+
+const idLen = 16
+
+var errInvalidToken = errors.New("auth: invalid, revoked, or expired bearer token")
+
+// BearerAuthenticator is saypi's original authentication scheme: an
+// opaque, HMAC-signed bearer token minted by CreateUser or the OAuth2
+// token endpoint and presented in an `Authorization: Bearer` header.
+// Tokens identify a session row rather than a user directly, so they
+// can be revoked without invalidating the HMAC secret for everyone.
+type BearerAuthenticator struct {
+	db *sqlx.DB
+
+	// EnableOAuth gates the /authorize and /token routes, letting
+	// third-party clients request delegated access to a saypi account.
+	// It defaults to false since most deployments have no such clients
+	// to register.
+	EnableOAuth bool
+
+	mu                 sync.RWMutex // guards the fields below, touched by RotateSecret
+	secret             []byte
+	oldSecret          []byte
+	oldSecretExpiresAt time.Time
+
+	cache *sessionCache
+
+	stopSweep chan struct{}
+	sweepDone chan struct{}
+}
+
+// NewBearerAuthenticator creates a BearerAuthenticator that signs and
+// verifies tokens with secret and persists sessions to db.
+func NewBearerAuthenticator(secret []byte, db *sqlx.DB) *BearerAuthenticator {
+	b := &BearerAuthenticator{
+		secret:    secret,
+		db:        db,
+		cache:     newSessionCache(sessionCacheSize),
+		stopSweep: make(chan struct{}),
+		sweepDone: make(chan struct{}),
+	}
+
+	go b.sweepSessions()
+
+	return b
+}
+
+// Close stops the background session sweeper. It should be called when
+// the BearerAuthenticator is no longer needed, e.g. via app.App's
+// closers.
+func (b *BearerAuthenticator) Close() error {
+	close(b.stopSweep)
+	<-b.sweepDone
+	return nil
+}
+
+// RotateSecret replaces the secret used to sign new tokens. Tokens
+// already signed with the previous secret continue to verify until
+// gracePeriod elapses, so rotating UserSecret doesn't invalidate
+// sessions that are already live.
+func (b *BearerAuthenticator) RotateSecret(secret []byte, gracePeriod time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.oldSecret = b.secret
+	b.oldSecretExpiresAt = time.Now().Add(gracePeriod)
+	b.secret = secret
+}
+
+// Routes registers the endpoints for minting bearer tokens: creating a
+// user, the existence check GetUser serves, and, if EnableOAuth is
+// set, the OAuth2 authorization-code flow.
+func (b *BearerAuthenticator) Routes(m *mux.Mux) {
+	m.RouteFuncC("POST", "/users", metrics.Instrument(b.CreateUser))
+	m.RouteFuncC("GET", "/users/:id", metrics.Instrument(b.GetUser))
+
+	if b.EnableOAuth {
+		m.RouteFuncC("GET", "/authorize", metrics.Instrument(b.Authorize))
+		m.RouteFuncC("POST", "/authorize", metrics.Instrument(b.Authorize))
+		m.RouteFuncC("POST", "/token", metrics.Instrument(b.Token))
+	}
+}
+
+func (b *BearerAuthenticator) Authenticate(r *http.Request) (string, error) {
+	sessionID := b.sessionIDFromRequest(r)
+	if sessionID == "" {
+		return "", errInvalidToken
+	}
+
+	userID, ok := b.lookupSession(sessionID)
+	if !ok {
+		return "", errInvalidToken
+	}
+
+	return userID, nil
+}
+
+func (b *BearerAuthenticator) sessionIDFromRequest(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return b.getUser(strings.TrimPrefix(auth, "Bearer "))
+}
+
+func (b *BearerAuthenticator) CreateUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := make([]byte, idLen)
+	if _, err := rand.Read(user); err != nil {
+		panic(err)
+	}
+
+	token, err := b.CreateSession(base64.URLEncoding.EncodeToString(user))
+	if err != nil {
+		// TODO: This shouldn't panic but handle some errors
+		panic(err)
+	}
+
+	res := struct {
+		ID string `json:"id"`
+	}{token}
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		panic(err)
+	}
+}
+
+// issueToken produces the bearer token for an id: the id followed by its
+// HMAC, base64-encoded. getUser reverses this to recover and verify the
+// id.
+func (b *BearerAuthenticator) issueToken(id []byte) string {
+	b.mu.RLock()
+	secret := b.secret
+	b.mu.RUnlock()
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(id)
+	token := append(append([]byte{}, id...), mac.Sum(nil)...)
+	return base64.URLEncoding.EncodeToString(token)
+}
+
+// GetUser reports whether the bearer token in the URL's :id segment
+// still authenticates, i.e. it verifies and its session hasn't been
+// revoked or outlived sessionTTL, the same checks Authenticate applies.
+// Checking the HMAC alone isn't enough: that stays valid for a
+// revoked or expired session, which would make GetUser say a logged-out
+// token is still good.
+func (b *BearerAuthenticator) GetUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	auth, ok := mux.GetURLVar(ctx, "id")
+	if !ok {
+		panic("GetUser called without an `id` URL Var")
+	}
+
+	sessionID := b.getUser(auth)
+	if sessionID == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, ok := b.lookupSession(sessionID); !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// getUser verifies auth as an HMAC-signed token and returns the
+// base64-encoded id it was issued for, or "" if auth doesn't verify
+// against either the current secret or, within its grace period, the
+// previous one.
+func (b *BearerAuthenticator) getUser(auth string) string {
+	raw, err := base64.URLEncoding.DecodeString(auth)
+	if err != nil {
+		return ""
+	}
+
+	b.mu.RLock()
+	secret, oldSecret, oldSecretExpiresAt := b.secret, b.oldSecret, b.oldSecretExpiresAt
+	b.mu.RUnlock()
+
+	if id, ok := verifyToken(raw, secret); ok {
+		return base64.URLEncoding.EncodeToString(id)
+	}
+	if oldSecret != nil && time.Now().Before(oldSecretExpiresAt) {
+		if id, ok := verifyToken(raw, oldSecret); ok {
+			return base64.URLEncoding.EncodeToString(id)
+		}
+	}
+	return ""
+}
+
+func verifyToken(raw, secret []byte) ([]byte, bool) {
+	mac := hmac.New(sha256.New, secret)
+	if len(raw) != idLen+mac.Size() {
+		return nil, false
+	}
+
+	id := raw[0:idLen]
+	msgMac := raw[idLen:]
+
+	mac.Write(id)
+	if hmac.Equal(msgMac, mac.Sum(nil)) {
+		return id, true
+	}
+	return nil, false
+}
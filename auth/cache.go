@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCacheSize = 4096
+	sessionCacheTTL  = 30 * time.Second
+)
+
+type cacheEntry struct {
+	key       string
+	userID    string
+	expiresAt time.Time
+}
+
+// sessionCache is a small in-process LRU that lets WrapC skip a DB
+// round-trip for sessions it has recently validated. Entries expire
+// quickly so a revocation still takes effect within sessionCacheTTL.
+type sessionCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func newSessionCache(capacity int) *sessionCache {
+	return &sessionCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *sessionCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return "", false
+	}
+
+	c.ll.MoveToFront(el)
+	return entry.userID, true
+}
+
+func (c *sessionCache) set(key, userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.userID = userID
+		entry.expiresAt = time.Now().Add(sessionCacheTTL)
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(&cacheEntry{key, userID, time.Now().Add(sessionCacheTTL)})
+	c.items[key] = el
+
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*cacheEntry).key)
+		}
+	}
+}
+
+func (c *sessionCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
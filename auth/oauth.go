@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+// codeLen is the number of random bytes used to generate authorization codes.
+const codeLen = 32
+
+// codeTTL is how long an authorization code remains valid before it must be
+// redeemed at /token.
+const codeTTL = 60 * time.Second
+
+var errCodeInvalid = errors.New("auth: authorization code is invalid, expired, or already used")
+
+// Client represents a third-party application registered to request
+// delegated authorization for saypi users via the /authorize and /token
+// endpoints.
+type Client struct {
+	ClientID     string         `db:"client_id"`
+	Name         string         `db:"name"`
+	RedirectURIs pq.StringArray `db:"redirect_uris"`
+}
+
+func (cl *Client) allowsRedirect(uri string) bool {
+	for _, allowed := range cl.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+var consentTmpl = template.Must(template.New("consent").Parse(`<!doctype html>
+<title>Authorize {{.Client.Name}}</title>
+<form method="POST" action="/authorize">
+  <input type="hidden" name="client_id" value="{{.Client.ClientID}}">
+  <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+  <input type="hidden" name="state" value="{{.State}}">
+  <p>{{.Client.Name}} is requesting access to your saypi account.</p>
+  <button type="submit" name="decision" value="allow">Allow</button>
+  <button type="submit" name="decision" value="deny">Deny</button>
+</form>
+`))
+
+// Authorize implements the /authorize step of the authorization-code
+// flow. A GET renders a consent page for the requesting client; a POST
+// records the user's decision and redirects back to redirect_uri with
+// either a ?code=&state= pair or an ?error=. Both steps authenticate the
+// resource owner with BearerAuthenticator.Authenticate only (other
+// configured backends are not tried), via the request's
+// own Authorization: Bearer session, rather than trusting a
+// self-reported user_id: the caller presents the bearer token it
+// already holds for the saypi account it wants to grant access to.
+func (b *BearerAuthenticator) Authorize(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "unable to parse request", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := b.Authenticate(r)
+	if err != nil {
+		http.Error(w, "Unable to authenticate request", http.StatusUnauthorized)
+		return
+	}
+
+	clientID := r.FormValue("client_id")
+	redirectURI := r.FormValue("redirect_uri")
+	state := r.FormValue("state")
+
+	client, err := b.getClient(clientID)
+	if err != nil {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if !client.allowsRedirect(redirectURI) {
+		http.Error(w, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if r.FormValue("response_type") != "code" {
+			http.Error(w, "only response_type=code is supported", http.StatusBadRequest)
+			return
+		}
+
+		if err := consentTmpl.Execute(w, struct {
+			Client      *Client
+			RedirectURI string
+			State       string
+		}{client, redirectURI, state}); err != nil {
+			panic(err)
+		}
+	case http.MethodPost:
+		if r.FormValue("decision") != "allow" {
+			redirectWithError(w, r, redirectURI, state, "access_denied")
+			return
+		}
+
+		code, err := b.issueAuthorizationCode(clientID, redirectURI, userID)
+		if err != nil {
+			http.Error(w, "unable to issue authorization code", http.StatusInternalServerError)
+			return
+		}
+
+		redirectWithCode(w, r, redirectURI, state, code)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Token implements the /token step: it exchanges a single-use
+// authorization code, issued to the same client_id and redirect_uri, for
+// a bearer token in the same format CreateUser produces so that WrapC
+// needs no changes to accept it.
+func (b *BearerAuthenticator) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "unable to parse request", http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("grant_type") != "authorization_code" {
+		http.Error(w, "unsupported grant_type", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := b.redeemAuthorizationCode(
+		r.FormValue("code"), r.FormValue("client_id"), r.FormValue("redirect_uri"),
+	)
+	if err != nil {
+		http.Error(w, "invalid or expired code", http.StatusBadRequest)
+		return
+	}
+
+	token, err := b.CreateSession(userID)
+	if err != nil {
+		http.Error(w, "unable to create session", http.StatusInternalServerError)
+		return
+	}
+
+	res := struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}{token, "bearer"}
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		panic(err)
+	}
+}
+
+func (b *BearerAuthenticator) getClient(clientID string) (*Client, error) {
+	var client Client
+	err := b.db.Get(&client, `SELECT client_id, name, redirect_uris FROM oauth_clients WHERE client_id = $1`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (b *BearerAuthenticator) issueAuthorizationCode(clientID, redirectURI, userID string) (string, error) {
+	raw := make([]byte, codeLen)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := base64.URLEncoding.EncodeToString(raw)
+
+	_, err := b.db.Exec(
+		`INSERT INTO authorization_codes (code, client_id, redirect_uri, user_id, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		code, clientID, redirectURI, userID, time.Now().Add(codeTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// redeemAuthorizationCode atomically marks a code used and returns the
+// user id it was issued for, provided it matches clientID/redirectURI,
+// hasn't expired, and hasn't been redeemed before.
+func (b *BearerAuthenticator) redeemAuthorizationCode(code, clientID, redirectURI string) (string, error) {
+	var userID string
+	err := b.db.Get(&userID, `
+		UPDATE authorization_codes
+		SET used_at = now()
+		WHERE code = $1 AND client_id = $2 AND redirect_uri = $3
+		  AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`,
+		code, clientID, redirectURI,
+	)
+	if err == sql.ErrNoRows {
+		return "", errCodeInvalid
+	} else if err != nil {
+		return "", err
+	}
+
+	return userID, nil
+}
+
+func redirectWithCode(w http.ResponseWriter, r *http.Request, redirectURI, state, code string) {
+	dest, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	q := dest.Query()
+	q.Set("code", code)
+	q.Set("state", state)
+	dest.RawQuery = q.Encode()
+
+	http.Redirect(w, r, dest.String(), http.StatusFound)
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, redirectURI, state, reason string) {
+	dest, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	q := dest.Query()
+	q.Set("error", reason)
+	q.Set("state", state)
+	dest.RawQuery = q.Encode()
+
+	http.Redirect(w, r, dest.String(), http.StatusFound)
+}
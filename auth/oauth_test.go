@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestClientAllowsRedirect(t *testing.T) {
+	client := &Client{
+		ClientID:     "abc123",
+		RedirectURIs: pq.StringArray{"https://example.com/callback"},
+	}
+
+	if !client.allowsRedirect("https://example.com/callback") {
+		t.Error("expected registered redirect_uri to be allowed")
+	}
+	if client.allowsRedirect("https://evil.example/callback") {
+		t.Error("expected unregistered redirect_uri to be rejected")
+	}
+}
+
+func TestRedirectWithCode(t *testing.T) {
+	r := httptest.NewRequest("POST", "/authorize", nil)
+	w := httptest.NewRecorder()
+
+	redirectWithCode(w, r, "https://example.com/callback", "xyz", "the-code")
+
+	loc := w.Result().Header.Get("Location")
+	want := "https://example.com/callback?code=the-code&state=xyz"
+	if loc != want {
+		t.Errorf("Location = %q, want %q", loc, want)
+	}
+}
+
+func TestRedirectWithError(t *testing.T) {
+	r := httptest.NewRequest("POST", "/authorize", nil)
+	w := httptest.NewRecorder()
+
+	redirectWithError(w, r, "https://example.com/callback", "xyz", "access_denied")
+
+	loc := w.Result().Header.Get("Location")
+	want := "https://example.com/callback?error=access_denied&state=xyz"
+	if loc != want {
+		t.Errorf("Location = %q, want %q", loc, want)
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/metcalf/saypi/mux"
+	"golang.org/x/net/context"
+)
+
+const (
+	// sessionTTL bounds how long a session remains valid after
+	// creation, regardless of activity. sweepSessions prunes rows past
+	// this age.
+	sessionTTL = 30 * 24 * time.Hour
+
+	sweepInterval = 5 * time.Minute
+)
+
+// CreateSession establishes a new session for userID and returns the
+// bearer token clients should present in their Authorization header.
+func (b *BearerAuthenticator) CreateSession(userID string) (string, error) {
+	sessionID := make([]byte, idLen)
+	if _, err := rand.Read(sessionID); err != nil {
+		return "", err
+	}
+
+	_, err := b.db.Exec(
+		`INSERT INTO sessions (id, user_id) VALUES ($1, $2)`,
+		base64.URLEncoding.EncodeToString(sessionID), userID,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return b.issueToken(sessionID), nil
+}
+
+// lookupSession returns the user id a session belongs to, provided it
+// hasn't been revoked or outlived sessionTTL. Results are cached briefly
+// so the hot WrapC path usually avoids a DB round-trip.
+func (b *BearerAuthenticator) lookupSession(sessionID string) (string, bool) {
+	if userID, ok := b.cache.get(sessionID); ok {
+		return userID, true
+	}
+
+	var userID string
+	err := b.db.Get(&userID, `
+		SELECT user_id FROM sessions
+		WHERE id = $1 AND revoked_at IS NULL AND created_at > $2`,
+		sessionID, time.Now().Add(-sessionTTL),
+	)
+	if err != nil {
+		return "", false
+	}
+
+	b.cache.set(sessionID, userID)
+	go b.touchSession(sessionID)
+
+	return userID, true
+}
+
+// touchSession records that a session was just used. It's best-effort:
+// a failure here shouldn't fail the request that triggered it.
+func (b *BearerAuthenticator) touchSession(sessionID string) {
+	b.db.Exec(`UPDATE sessions SET last_seen_at = now() WHERE id = $1`, sessionID)
+}
+
+// DeleteCurrentSession revokes the session the request authenticated
+// with, logging the caller out of that session only.
+func (b *BearerAuthenticator) DeleteCurrentSession(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	sessionID := b.sessionIDFromRequest(r)
+	if sessionID == "" {
+		http.Error(w, "Invalid authentication string", http.StatusUnauthorized)
+		return
+	}
+
+	if err := b.revokeSession(sessionID); err != nil {
+		panic(err)
+	}
+
+	b.cache.delete(sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteSession revokes one of the authenticated user's sessions by id.
+func (b *BearerAuthenticator) DeleteSession(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, ok := mux.GetURLVar(ctx, "id")
+	if !ok {
+		panic("DeleteSession called without an `id` URL Var")
+	}
+	userID, _ := ctx.Value("userID").(string)
+
+	found, err := b.revokeSessionForUser(id, userID)
+	if err != nil {
+		panic(err)
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	b.cache.delete(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *BearerAuthenticator) revokeSession(id string) error {
+	_, err := b.db.Exec(
+		`UPDATE sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`,
+		id,
+	)
+	return err
+}
+
+// revokeSessionForUser revokes session id only if it belongs to userID,
+// so one user can't revoke another's session by guessing its id.
+func (b *BearerAuthenticator) revokeSessionForUser(id, userID string) (bool, error) {
+	res, err := b.db.Exec(
+		`UPDATE sessions SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		id, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// sweepSessions periodically prunes revoked and expired session rows
+// until Close is called.
+func (b *BearerAuthenticator) sweepSessions() {
+	defer close(b.sweepDone)
+
+	t := time.NewTicker(sweepInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			// TODO: wrap with logging once request logging lands
+			b.db.Exec(`DELETE FROM sessions WHERE revoked_at IS NOT NULL OR created_at < $1`, time.Now().Add(-sessionTTL))
+		case <-b.stopSweep:
+			return
+		}
+	}
+}
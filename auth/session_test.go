@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIssueTokenVerifyTokenRoundtrip(t *testing.T) {
+	b := &BearerAuthenticator{secret: []byte("s3cret")}
+
+	id := []byte("0123456789abcdef")
+	token := b.issueToken(id)
+
+	got := b.getUser(token)
+	if got == "" {
+		t.Fatal("expected freshly issued token to verify")
+	}
+}
+
+func TestGetUserRejectsTamperedToken(t *testing.T) {
+	b := &BearerAuthenticator{secret: []byte("s3cret")}
+
+	token := b.issueToken([]byte("0123456789abcdef"))
+	tampered := token[:len(token)-1] + "x"
+
+	if got := b.getUser(tampered); got != "" {
+		t.Errorf("expected tampered token to be rejected, got userID %q", got)
+	}
+}
+
+func TestRotateSecretGracePeriod(t *testing.T) {
+	b := &BearerAuthenticator{secret: []byte("old-secret")}
+	oldToken := b.issueToken([]byte("0123456789abcdef"))
+
+	b.RotateSecret([]byte("new-secret"), time.Minute)
+
+	if got := b.getUser(oldToken); got == "" {
+		t.Error("expected token signed with old secret to verify during grace period")
+	}
+
+	newToken := b.issueToken([]byte("fedcba9876543210"))
+	if got := b.getUser(newToken); got == "" {
+		t.Error("expected token signed with new secret to verify")
+	}
+}
+
+func TestRotateSecretExpiresOldSecret(t *testing.T) {
+	b := &BearerAuthenticator{secret: []byte("old-secret")}
+	oldToken := b.issueToken([]byte("0123456789abcdef"))
+
+	b.RotateSecret([]byte("new-secret"), -time.Minute) // already-expired grace period
+
+	if got := b.getUser(oldToken); got != "" {
+		t.Errorf("expected token signed with expired old secret to be rejected, got userID %q", got)
+	}
+}
+
+func TestSessionCacheGetSetDelete(t *testing.T) {
+	c := newSessionCache(2)
+
+	if _, ok := c.get("missing"); ok {
+		t.Fatal("expected lookup of unset key to miss")
+	}
+
+	c.set("a", "user-a")
+	if userID, ok := c.get("a"); !ok || userID != "user-a" {
+		t.Errorf("get(a) = (%q, %v), want (user-a, true)", userID, ok)
+	}
+
+	c.delete("a")
+	if _, ok := c.get("a"); ok {
+		t.Error("expected deleted key to miss")
+	}
+}
+
+func TestSessionCacheEvictsOldestOverCapacity(t *testing.T) {
+	c := newSessionCache(2)
+
+	c.set("a", "user-a")
+	c.set("b", "user-b")
+	c.set("c", "user-c") // should evict "a", the least recently used
+
+	if _, ok := c.get("a"); ok {
+		t.Error("expected oldest entry to be evicted once capacity was exceeded")
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Error("expected \"b\" to still be cached")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("expected \"c\" to still be cached")
+	}
+}
+
+func TestSessionCacheExpires(t *testing.T) {
+	c := newSessionCache(10)
+	c.set("a", "user-a")
+
+	// Force the entry to look expired without sleeping sessionCacheTTL.
+	el := c.items["a"]
+	el.Value.(*cacheEntry).expiresAt = time.Now().Add(-time.Second)
+
+	if _, ok := c.get("a"); ok {
+		t.Error("expected expired entry to miss")
+	}
+}
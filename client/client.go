@@ -2,10 +2,12 @@ package client
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -146,9 +148,46 @@ func (c *Client) NewRequest(rt Route, rtVars Vars, form *url.Values) (*http.Requ
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
 
+	// Mutating requests get an Idempotency-Key so that retrying the same
+	// *http.Request after a network error is safe: the server dedupes on
+	// this key rather than applying the request twice. Callers that need
+	// to retry a logically-equivalent request built fresh from scratch
+	// should carry the key over themselves with SetIdempotencyKey.
+	if isUnsafeMethod(method) {
+		req.Header.Set("Idempotency-Key", newIdempotencyKey())
+	}
+
 	return req, nil
 }
 
+// SetIdempotencyKey overrides the Idempotency-Key NewRequest generated
+// for req. Most callers don't need this: retrying the same *http.Request
+// reuses whatever key NewRequest already assigned.
+func (c *Client) SetIdempotencyKey(req *http.Request, key string) {
+	req.Header.Set("Idempotency-Key", key)
+}
+
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case "POST", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+// newIdempotencyKey generates a random UUIDv4-style string.
+func newIdempotencyKey() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 // Do sends an API request and returns the API response. The API
 // response is JSON-decoded and stored in the value pointed to by
 // v. If a known usererror response is returned, the error will be a
@@ -193,18 +232,43 @@ func (c *Client) SetAuthorization(auth string) {
 	c.auth = auth
 }
 
+// maxIdempotentRetries bounds how many times execute retries a request
+// after a transport-level error. It only applies to requests NewRequest
+// gave an Idempotency-Key: retries resend the same *http.Request, so
+// the server's idempotency middleware dedupes them instead of
+// re-applying the mutation, which is what makes the retry safe.
+const maxIdempotentRetries = 2
+
 func (c *Client) execute(rt Route, rtVars Vars, form *url.Values, v interface{}) (*http.Response, error) {
 	req, err := c.NewRequest(rt, rtVars, form)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.Do(req, v)
-	if err != nil {
-		return nil, err
+	retriesLeft := 0
+	if req.Header.Get("Idempotency-Key") != "" {
+		retriesLeft = maxIdempotentRetries
 	}
 
-	return resp, err
+	for {
+		resp, err := c.Do(req, v)
+		if err == nil {
+			return resp, nil
+		}
+
+		if _, ok := err.(net.Error); !ok || retriesLeft <= 0 {
+			return resp, err
+		}
+		retriesLeft--
+
+		if req.GetBody != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				return resp, err
+			}
+			req.Body = body
+		}
+	}
 }
 
 func (c *Client) CreateUser() (*auth.User, error) {
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/metcalf/saypi/say"
+	"goji.io/pattern"
+)
+
+type fakeRoute struct {
+	methods map[string]struct{}
+	path    string
+}
+
+func (r fakeRoute) HTTPMethods() map[string]struct{} { return r.methods }
+func (r fakeRoute) URLPath(map[pattern.Variable]string) (string, error) {
+	return r.path, nil
+}
+
+func TestNewRequestSetsIdempotencyKeyForUnsafeMethods(t *testing.T) {
+	c := New(&url.URL{Scheme: "http", Host: "example.com"}, nil)
+	rt := fakeRoute{methods: map[string]struct{}{"POST": {}}, path: "/moods/happy"}
+
+	req, err := c.NewRequest(rt, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Header.Get("Idempotency-Key") == "" {
+		t.Error("expected NewRequest to set Idempotency-Key for a POST")
+	}
+}
+
+func TestNewRequestOmitsIdempotencyKeyForSafeMethods(t *testing.T) {
+	c := New(&url.URL{Scheme: "http", Host: "example.com"}, nil)
+	rt := fakeRoute{methods: map[string]struct{}{"GET": {}}, path: "/moods/happy"}
+
+	req, err := c.NewRequest(rt, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Header.Get("Idempotency-Key") != "" {
+		t.Error("expected NewRequest not to set Idempotency-Key for a GET")
+	}
+}
+
+type netErr struct{ error }
+
+func (netErr) Timeout() bool   { return false }
+func (netErr) Temporary() bool { return true }
+
+func TestExecuteRetriesTransportErrorsWithSameIdempotencyKey(t *testing.T) {
+	c := New(&url.URL{Scheme: "http", Host: "example.com"}, nil)
+
+	var seenKeys []string
+	attempts := 0
+	c.do = func(req *http.Request) (*http.Response, error) {
+		attempts++
+		seenKeys = append(seenKeys, req.Header.Get("Idempotency-Key"))
+		if attempts < 3 {
+			return nil, &net.OpError{Op: "dial", Err: netErr{errors.New("connection refused")}}
+		}
+		return nil, errors.New("boom: not a net.Error, so this ends the retry loop")
+	}
+
+	mood := &say.Mood{Name: "happy"}
+	err := c.SetMood(mood)
+	if err == nil {
+		t.Fatal("expected an error from the final non-retryable failure")
+	}
+
+	if attempts != 3 {
+		t.Fatalf("attempts = %d, want 3", attempts)
+	}
+	for i, key := range seenKeys {
+		if key == "" || key != seenKeys[0] {
+			t.Errorf("attempt %d Idempotency-Key = %q, want %q on every retry", i, key, seenKeys[0])
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Package idempotency deduplicates unsafe HTTP requests that carry an
+// Idempotency-Key header, so a client retrying a mutating request after
+// a network error doesn't risk applying it twice.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/metcalf/saypi/mux"
+	"golang.org/x/net/context"
+)
+
+const (
+	// keyTTL bounds how long a key is remembered. sweep prunes rows
+	// past this age.
+	keyTTL = 24 * time.Hour
+
+	// leaseTTL bounds how long a key may stay 'in_flight' before
+	// reserve treats it as abandoned (the handler that claimed it
+	// panicked or its process died) and reclaims it for a fresh
+	// attempt. It's far shorter than keyTTL, which only bounds how long
+	// a *completed* key is remembered for replay.
+	leaseTTL = 30 * time.Second
+
+	sweepInterval = 15 * time.Minute
+
+	// statusTooEarly is RFC 8470's 425, used when a key is still being
+	// processed by this or another instance. Spelled out since older
+	// net/http versions don't define http.StatusTooEarly.
+	statusTooEarly = 425
+)
+
+// errKeyInFlight means another request is currently handling this key,
+// and its lease hasn't expired yet.
+var errKeyInFlight = errors.New("idempotency: key is already in flight")
+
+// Middleware deduplicates POST/PUT/DELETE requests carrying an
+// Idempotency-Key header: a repeat of a key with the same method, path
+// and body replays the stored response, a repeat that differs in any of
+// them gets 409, and a key another request is still working on gets 425.
+type Middleware struct {
+	db *sqlx.DB
+
+	mu       sync.Mutex
+	inFlight map[string]struct{} // "userID\x00key" this process is handling right now
+
+	stopSweep chan struct{}
+	sweepDone chan struct{}
+}
+
+// New creates a Middleware that persists keys and responses to db.
+func New(db *sqlx.DB) *Middleware {
+	m := &Middleware{
+		db:        db,
+		inFlight:  make(map[string]struct{}),
+		stopSweep: make(chan struct{}),
+		sweepDone: make(chan struct{}),
+	}
+
+	go m.sweep()
+
+	return m
+}
+
+// Close stops the background key sweeper.
+func (m *Middleware) Close() error {
+	close(m.stopSweep)
+	<-m.sweepDone
+	return nil
+}
+
+// WrapC should wrap the handler chain after the auth chain resolves a
+// userID, since keys are scoped per user.
+func (m *Middleware) WrapC(inner mux.HandlerC) mux.HandlerC {
+	return mux.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if !isUnsafe(r.Method) || key == "" {
+			inner.ServeHTTPC(ctx, w, r)
+			return
+		}
+
+		userID, _ := ctx.Value("userID").(string)
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "unable to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		requestHash := hashRequest(r.Method, r.URL.Path, body)
+
+		lockKey := userID + "\x00" + key
+		if !m.claim(lockKey) {
+			http.Error(w, "a request with this Idempotency-Key is still in flight", statusTooEarly)
+			return
+		}
+		defer m.release(lockKey)
+
+		if stored, err := m.lookup(userID, key); err == nil {
+			if stored.RequestHash != requestHash {
+				http.Error(w, "Idempotency-Key was previously used with a different request", http.StatusConflict)
+				return
+			}
+			replay(w, stored)
+			return
+		}
+
+		if err := m.reserve(userID, key, requestHash); err == errKeyInFlight {
+			http.Error(w, "a request with this Idempotency-Key is still in flight", statusTooEarly)
+			return
+		} else if err != nil {
+			http.Error(w, "unable to process request", http.StatusInternalServerError)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK, body: &bytes.Buffer{}}
+		inner.ServeHTTPC(ctx, rec, r)
+
+		if err := m.complete(userID, key, rec); err != nil {
+			// TODO: wrap with logging once request logging has a hook here
+		}
+	})
+}
+
+func (m *Middleware) claim(lockKey string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, busy := m.inFlight[lockKey]; busy {
+		return false
+	}
+	m.inFlight[lockKey] = struct{}{}
+	return true
+}
+
+func (m *Middleware) release(lockKey string) {
+	m.mu.Lock()
+	delete(m.inFlight, lockKey)
+	m.mu.Unlock()
+}
+
+type storedResponse struct {
+	RequestHash string `db:"request_hash"`
+	Status      int    `db:"status"`
+	Body        []byte `db:"response_body"`
+	Headers     []byte `db:"response_headers"`
+}
+
+func (m *Middleware) lookup(userID, key string) (*storedResponse, error) {
+	var sr storedResponse
+	err := m.db.Get(&sr, `
+		SELECT request_hash, status, response_body, response_headers
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND state = 'complete'`,
+		userID, key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &sr, nil
+}
+
+// reserve claims a key as in-flight, either by inserting it fresh or,
+// via the ON CONFLICT clause, by reclaiming an in_flight row whose
+// lease has expired (the request that reserved it never called
+// complete, most likely because its handler panicked or its process
+// died). Returns errKeyInFlight if the key is held by a live lease.
+func (m *Middleware) reserve(userID, key, requestHash string) error {
+	var reserved bool
+	err := m.db.Get(&reserved, `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, state, created_at)
+		VALUES ($1, $2, $3, 'in_flight', now())
+		ON CONFLICT (user_id, key) DO UPDATE
+			SET request_hash = $3, state = 'in_flight', created_at = now()
+			WHERE idempotency_keys.state = 'in_flight'
+			  AND idempotency_keys.created_at < $4
+		RETURNING true`,
+		userID, key, requestHash, time.Now().Add(-leaseTTL),
+	)
+	if err == sql.ErrNoRows {
+		return errKeyInFlight
+	}
+	return err
+}
+
+func (m *Middleware) complete(userID, key string, rec *recorder) error {
+	headers, err := json.Marshal(rec.Header())
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.Exec(`
+		UPDATE idempotency_keys
+		SET state = 'complete', status = $3, response_body = $4, response_headers = $5
+		WHERE user_id = $1 AND key = $2`,
+		userID, key, rec.status, rec.body.Bytes(), headers,
+	)
+	return err
+}
+
+// sweep periodically prunes keys older than keyTTL until Close is
+// called.
+func (m *Middleware) sweep() {
+	defer close(m.sweepDone)
+
+	t := time.NewTicker(sweepInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			// TODO: wrap with logging once request logging has a hook here
+			m.db.Exec(`DELETE FROM idempotency_keys WHERE created_at < $1`, time.Now().Add(-keyTTL))
+		case <-m.stopSweep:
+			return
+		}
+	}
+}
+
+func replay(w http.ResponseWriter, sr *storedResponse) {
+	var headers http.Header
+	if err := json.Unmarshal(sr.Headers, &headers); err == nil {
+		for k, vs := range headers {
+			for _, v := range vs {
+				w.Header().Add(k, v)
+			}
+		}
+	}
+	w.WriteHeader(sr.Status)
+	w.Write(sr.Body)
+}
+
+func hashRequest(method, path string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, method)
+	io.WriteString(h, path)
+	h.Write(body)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func isUnsafe(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// recorder captures a handler's response so it can be persisted for
+// replay, while still writing through to the real ResponseWriter.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
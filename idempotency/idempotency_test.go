@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsUnsafe(t *testing.T) {
+	cases := map[string]bool{
+		"GET":    false,
+		"HEAD":   false,
+		"POST":   true,
+		"PUT":    true,
+		"DELETE": true,
+	}
+	for method, want := range cases {
+		if got := isUnsafe(method); got != want {
+			t.Errorf("isUnsafe(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestHashRequestStability(t *testing.T) {
+	a := hashRequest("POST", "/moods/happy", []byte(`{"intensity":5}`))
+	b := hashRequest("POST", "/moods/happy", []byte(`{"intensity":5}`))
+	if a != b {
+		t.Error("expected identical requests to hash identically")
+	}
+
+	c := hashRequest("POST", "/moods/happy", []byte(`{"intensity":6}`))
+	if a == c {
+		t.Error("expected differing request bodies to hash differently")
+	}
+}
+
+func TestClaimRelease(t *testing.T) {
+	m := &Middleware{inFlight: make(map[string]struct{})}
+
+	if !m.claim("user-1\x00key-1") {
+		t.Fatal("expected first claim to succeed")
+	}
+	if m.claim("user-1\x00key-1") {
+		t.Error("expected second claim of the same lock key to fail while held")
+	}
+
+	m.release("user-1\x00key-1")
+	if !m.claim("user-1\x00key-1") {
+		t.Error("expected claim to succeed again after release")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	sr := &storedResponse{
+		Status:  201,
+		Body:    []byte(`{"ok":true}`),
+		Headers: []byte(`{"Content-Type":["application/json"]}`),
+	}
+
+	w := httptest.NewRecorder()
+	replay(w, sr)
+
+	if w.Code != 201 {
+		t.Errorf("status = %d, want 201", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if w.Body.String() != `{"ok":true}` {
+		t.Errorf("body = %q", w.Body.String())
+	}
+}
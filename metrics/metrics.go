@@ -0,0 +1,76 @@
+// Package metrics instruments saypi's HTTP handlers with Prometheus
+// counters/histograms and a structured request log, and exposes the
+// resulting series on GET /metrics.
+package metrics
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "saypi_http_requests_total",
+			Help: "Total number of HTTP requests handled, by method, route, and status.",
+		},
+		[]string{"method", "route", "status"},
+	)
+
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "saypi_http_request_duration_seconds",
+			Help: "HTTP request latency in seconds, by method and route.",
+		},
+		[]string{"method", "route"},
+	)
+
+	authFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "saypi_auth_failures_total",
+			Help: "Total number of requests rejected by the auth chain, by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	dbOpenConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "saypi_db_open_connections",
+		Help: "Number of established connections to Postgres, both in use and idle.",
+	})
+	dbInUseConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "saypi_db_in_use_connections",
+		Help: "Number of Postgres connections currently in use.",
+	})
+	dbIdleConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "saypi_db_idle_connections",
+		Help: "Number of idle Postgres connections in the pool.",
+	})
+	dbWaitCountTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "saypi_db_wait_count_total",
+		Help: "Total number of connections that had to wait because none was free.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		requestsTotal, requestDuration, authFailuresTotal,
+		dbOpenConnections, dbInUseConnections, dbIdleConnections, dbWaitCountTotal,
+	)
+}
+
+// AuthFailure records a request rejected by the auth chain for reason,
+// a short, low-cardinality tag such as "invalid_bearer_token".
+func AuthFailure(reason string) {
+	authFailuresTotal.WithLabelValues(reason).Inc()
+}
+
+// ObserveDBStats publishes db's connection pool stats as gauges, so
+// operators can tune Configuration.DBMaxIdle/DBMaxOpen from real data.
+// Call it periodically, e.g. from a ticker in app.New.
+func ObserveDBStats(db *sqlx.DB) {
+	stats := db.Stats()
+	dbOpenConnections.Set(float64(stats.OpenConnections))
+	dbInUseConnections.Set(float64(stats.InUse))
+	dbIdleConnections.Set(float64(stats.Idle))
+	dbWaitCountTotal.Set(float64(stats.WaitCount))
+}
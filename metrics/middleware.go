@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/metcalf/saypi/mux"
+	"golang.org/x/net/context"
+	"goji.io/pattern"
+)
+
+// WrapC instruments inner with per-route request counters and latency
+// histograms. It uses the matched goji pattern, not the raw URL, as the
+// route label so that path variables (user ids, session ids, ...) don't
+// blow up label cardinality.
+func WrapC(inner mux.HandlerC) mux.HandlerC {
+	return mux.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		inner.ServeHTTPC(ctx, sw, r)
+
+		route := routeLabel(ctx)
+		requestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(sw.status)).Inc()
+		requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// LogC emits one structured line per request: method, matched route,
+// status, duration, and the userID the auth chain resolved, if any.
+func LogC(inner mux.HandlerC) mux.HandlerC {
+	return mux.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		inner.ServeHTTPC(ctx, sw, r)
+
+		userID, _ := ctx.Value("userID").(string)
+		log.Printf(
+			"method=%s route=%s status=%d duration=%s user_id=%q",
+			r.Method, routeLabel(ctx), sw.status, time.Since(start), userID,
+		)
+	})
+}
+
+// Instrument wraps fn with WrapC and LogC so it's the goji route
+// handler itself - not the mux dispatching to it - that records fn's
+// metrics and structured log line. By the time goji calls fn, the
+// matched route pattern and (behind auth.Controller.WrapC) the
+// authenticated userID are already on fn's context; neither survives
+// being read back through a ServeHTTPC call that's already returned,
+// so wrapping has to happen at the terminal handler, not around the
+// mux.
+func Instrument(fn func(ctx context.Context, w http.ResponseWriter, r *http.Request)) func(context.Context, http.ResponseWriter, *http.Request) {
+	return WrapC(LogC(mux.HandlerFuncC(fn))).ServeHTTPC
+}
+
+func routeLabel(ctx context.Context) string {
+	if route := pattern.Path(ctx); route != "" {
+		return route
+	}
+	return "unmatched"
+}
+
+// statusWriter records the status code a handler wrote so middleware
+// wrapping it can observe the outcome after ServeHTTPC returns.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}